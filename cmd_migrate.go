@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/application-research/estuary/migrations"
+	"github.com/urfave/cli/v2"
+	"gorm.io/gorm"
+)
+
+// MigrateCmd exposes the schema migration runner as `estuary migrate ...`.
+// It is registered alongside the other top-level commands in main.go.
+var MigrateCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "manage database schema migrations",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "up",
+			Usage: "apply all pending migrations",
+			Action: func(cctx *cli.Context) error {
+				db, err := setupDatabase(cctx)
+				if err != nil {
+					return err
+				}
+				return migrations.Up(db)
+			},
+		},
+		{
+			Name:  "down",
+			Usage: "revert the most recently applied migration",
+			Action: func(cctx *cli.Context) error {
+				db, err := setupDatabase(cctx)
+				if err != nil {
+					return err
+				}
+				return migrations.Down(db)
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "show which migrations have been applied",
+			Action: func(cctx *cli.Context) error {
+				db, err := setupDatabase(cctx)
+				if err != nil {
+					return err
+				}
+				statuses, err := migrations.StatusList(db)
+				if err != nil {
+					return err
+				}
+				for _, s := range statuses {
+					state := "pending"
+					if s.Applied {
+						state = "applied"
+					}
+					fmt.Printf("%4d  %-40s %s\n", s.Version, s.Name, state)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "to",
+			Usage:     "migrate up or down to the given version",
+			ArgsUsage: "<version>",
+			Action: func(cctx *cli.Context) error {
+				if cctx.NArg() != 1 {
+					return fmt.Errorf("must specify exactly one target version")
+				}
+				version, err := strconv.Atoi(cctx.Args().First())
+				if err != nil {
+					return fmt.Errorf("invalid version %q: %w", cctx.Args().First(), err)
+				}
+				db, err := setupDatabase(cctx)
+				if err != nil {
+					return err
+				}
+				return migrations.To(db, version)
+			},
+		},
+	},
+}
+
+// setupDatabase opens the raw gorm connection for the `migrate` subcommands,
+// bypassing NewDBMgr so that these commands work even when the schema is
+// behind the binary and auto-migrate is disabled.
+func setupDatabase(cctx *cli.Context) (*gorm.DB, error) {
+	db, _, err := openRawDB(cctx.String("database"))
+	return db, err
+}
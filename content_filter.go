@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"gorm.io/gorm"
+)
+
+// ContentFilter is a typed AST for building dynamic queries that the fixed
+// WithX builder chains on ContentsQuery/DealsQuery can't express: OR, NOT,
+// and arbitrary comparisons. HTTP handlers build a ContentFilter from URL
+// query params and pass it to ContentsQuery.Search or DealsQuery.Search,
+// which render it to portable SQL via squirrel and run it through
+// gorm.Raw.
+//
+// TODO: this only ever selects from a single table (contents or
+// content_deals); there's no join or correlated-subquery primitive in the
+// AST yet, so a filter still can't express something like "contents with
+// no successful deal in the last N days" in one Search call. Needs a follow
+// up once there's a concrete handler that needs it.
+type ContentFilter interface {
+	toSquirrel() sq.Sqlizer
+}
+
+type andFilter struct{ terms []ContentFilter }
+type orFilter struct{ terms []ContentFilter }
+type notFilter struct{ term ContentFilter }
+type cmpFilter struct {
+	column string
+	op     string
+	value  interface{}
+}
+
+// And matches rows satisfying every term.
+func And(terms ...ContentFilter) ContentFilter { return andFilter{terms} }
+
+// Or matches rows satisfying any term.
+func Or(terms ...ContentFilter) ContentFilter { return orFilter{terms} }
+
+// Not negates term.
+func Not(term ContentFilter) ContentFilter { return notFilter{term} }
+
+// Cmp compares column against value using op ("=", "!=", ">", ">=", "<",
+// "<=", or "IN" for a slice value).
+func Cmp(column, op string, value interface{}) ContentFilter {
+	return cmpFilter{column: column, op: op, value: value}
+}
+
+func (f andFilter) toSquirrel() sq.Sqlizer {
+	and := make(sq.And, 0, len(f.terms))
+	for _, t := range f.terms {
+		and = append(and, t.toSquirrel())
+	}
+	return and
+}
+
+func (f orFilter) toSquirrel() sq.Sqlizer {
+	or := make(sq.Or, 0, len(f.terms))
+	for _, t := range f.terms {
+		or = append(or, t.toSquirrel())
+	}
+	return or
+}
+
+func (f notFilter) toSquirrel() sq.Sqlizer {
+	sql, args, err := f.term.toSquirrel().ToSql()
+	if err != nil {
+		return sq.Expr("1 = 0")
+	}
+	return sq.Expr("NOT ("+sql+")", args...)
+}
+
+func (f cmpFilter) toSquirrel() sq.Sqlizer {
+	switch f.op {
+	case "=":
+		return sq.Eq{f.column: f.value}
+	case "!=":
+		return sq.NotEq{f.column: f.value}
+	case ">":
+		return sq.Gt{f.column: f.value}
+	case ">=":
+		return sq.GtOrEq{f.column: f.value}
+	case "<":
+		return sq.Lt{f.column: f.value}
+	case "<=":
+		return sq.LtOrEq{f.column: f.value}
+	case "IN":
+		return sq.Eq{f.column: f.value}
+	default:
+		return sq.Expr(fmt.Sprintf("%s %s ?", f.column, f.op), f.value)
+	}
+}
+
+// evalFilter evaluates filter against a row without touching SQL at all, by
+// walking the same And/Or/Not/Cmp tree toSquirrel renders, and resolving
+// each cmpFilter's column through get. memContentsQuery.Search and
+// memDealsQuery.Search use this since they have no SQL engine to hand the
+// squirrel-rendered query to.
+func evalFilter(filter ContentFilter, get func(column string) (interface{}, bool)) bool {
+	switch f := filter.(type) {
+	case andFilter:
+		for _, term := range f.terms {
+			if !evalFilter(term, get) {
+				return false
+			}
+		}
+		return true
+	case orFilter:
+		for _, term := range f.terms {
+			if evalFilter(term, get) {
+				return true
+			}
+		}
+		return false
+	case notFilter:
+		return !evalFilter(f.term, get)
+	case cmpFilter:
+		got, ok := get(f.column)
+		if !ok {
+			return false
+		}
+		return evalCmp(got, f.op, f.value)
+	default:
+		return false
+	}
+}
+
+// evalCmp compares got (a resolved column value) against want using op, the
+// in-memory equivalent of the SQL comparison cmpFilter.toSquirrel renders.
+func evalCmp(got interface{}, op string, want interface{}) bool {
+	switch op {
+	case "=":
+		return fmt.Sprint(got) == fmt.Sprint(want)
+	case "!=":
+		return fmt.Sprint(got) != fmt.Sprint(want)
+	case "IN":
+		rv := reflect.ValueOf(want)
+		if rv.Kind() != reflect.Slice {
+			return fmt.Sprint(got) == fmt.Sprint(want)
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if fmt.Sprint(got) == fmt.Sprint(rv.Index(i).Interface()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	a, aOk := toOrderable(got)
+	b, bOk := toOrderable(want)
+	if !aOk || !bOk {
+		return false
+	}
+	switch op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	default:
+		return false
+	}
+}
+
+// toOrderable converts v to a float64 so evalCmp can compare the ordered
+// operators (">", ">=", "<", "<=") across the numeric and time.Time column
+// types Content/contentDeal actually use.
+func toOrderable(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case time.Time:
+		return float64(n.UnixNano()), true
+	default:
+		return 0, false
+	}
+}
+
+// placeholderFormatFor returns the squirrel placeholder style matching db's
+// dialect ($1, $2... for postgres/cockroach, ? everywhere else).
+func placeholderFormatFor(db *gorm.DB) sq.PlaceholderFormat {
+	if dialectOf(db) == dialectPostgres {
+		return sq.Dollar
+	}
+	return sq.Question
+}
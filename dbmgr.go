@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"strings"
 	"time"
 
+	sq "github.com/Masterminds/squirrel"
+	"github.com/application-research/estuary/migrations"
 	"github.com/application-research/estuary/util"
 	gocid "github.com/ipfs/go-cid"
+	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
 type DBSortOrder int
@@ -19,9 +25,39 @@ const (
 	OrderAscending  DBSortOrder = 1
 )
 
-type DBMgr struct{ DB *gorm.DB }
+// defaultCreateBatchSize is used by CreateInBatches callers that don't have
+// a specific size in mind. It's comfortably under postgres' 65535 bind
+// parameter limit even for tables with a couple dozen columns.
+const defaultCreateBatchSize = 500
 
-func (mgr *DBMgr) Users() *UsersQuery {
+type DBMgr struct {
+	DB        *gorm.DB
+	stmtCache *boundedStmtCache
+}
+
+// DBStats reports connection-pool and prepared-statement cache health, for
+// operators watching ingest throughput.
+type DBStats struct {
+	sql.DBStats
+	PreparedStmtHitRate float64
+}
+
+// Stats returns a snapshot of the underlying connection pool's stats plus
+// this DBMgr's prepared-statement cache hit rate.
+func (mgr *DBMgr) Stats() (DBStats, error) {
+	sqldb, err := mgr.DB.DB()
+	if err != nil {
+		return DBStats{}, err
+	}
+
+	stats := DBStats{DBStats: sqldb.Stats()}
+	if mgr.stmtCache != nil {
+		stats.PreparedStmtHitRate = mgr.stmtCache.hitRate()
+	}
+	return stats, nil
+}
+
+func (mgr *DBMgr) Users() UserRepo {
 	return NewUsersQuery(mgr.DB)
 }
 
@@ -29,7 +65,7 @@ func (mgr *DBMgr) AuthTokens() *AuthTokensQuery {
 	return NewAuthTokensQuery(mgr.DB)
 }
 
-func (mgr *DBMgr) Contents() *ContentsQuery {
+func (mgr *DBMgr) Contents() ContentRepo {
 	return NewContentsQuery(mgr.DB)
 }
 
@@ -41,7 +77,7 @@ func (mgr *DBMgr) ObjRefs() *ObjRefsQuery {
 	return NewObjRefsQuery(mgr.DB)
 }
 
-func (mgr *DBMgr) Deals() *DealsQuery {
+func (mgr *DBMgr) Deals() DealRepo {
 	return NewDealsQuery(mgr.DB)
 }
 
@@ -53,59 +89,149 @@ func (mgr *DBMgr) CollectionRefs() *CollectionRefsQuery {
 	return NewCollectionRefsQuery(mgr.DB)
 }
 
-func NewDBMgr(dbval string) (*DBMgr, error) {
-	parts := strings.SplitN(dbval, "=", 2)
+// dsn is one `DBTYPE=PARAMS` segment of a database string.
+type dsn struct {
+	dbtype string
+	params string
+}
+
+func parseDSN(s string) (dsn, error) {
+	parts := strings.SplitN(s, "=", 2)
 	if len(parts) == 1 {
-		return nil, fmt.Errorf("format for database string is 'DBTYPE=PARAMS'")
+		return dsn{}, fmt.Errorf("format for database string is 'DBTYPE=PARAMS'")
 	}
+	return dsn{dbtype: parts[0], params: parts[1]}, nil
+}
 
-	var dial gorm.Dialector
-	switch parts[0] {
+func dialectorFor(d dsn) (gorm.Dialector, error) {
+	switch d.dbtype {
 	case "sqlite":
-		dial = sqlite.Open(parts[1])
+		return sqlite.Open(d.params), nil
 	case "postgres":
-		dial = postgres.Open(parts[1])
+		return postgres.Open(d.params), nil
+	case "cockroach":
+		// CockroachDB speaks the postgres wire protocol, so the postgres
+		// dialector works unmodified.
+		return postgres.Open(d.params), nil
+	case "mysql":
+		return mysql.Open(d.params), nil
 	default:
-		return nil, fmt.Errorf("unsupported or unrecognized db type: %s", parts[0])
+		return nil, fmt.Errorf("unsupported or unrecognized db type: %s", d.dbtype)
+	}
+}
+
+// openRawDB opens the dialector(s) for dbval without running migrations or
+// seeding any data. It is used both by NewDBMgr and by the `estuary migrate`
+// subcommands, which need a connection even when the schema is behind the
+// binary.
+//
+// dbval is normally a single 'DBTYPE=PARAMS' segment. It may also carry a
+// ';replica=dsn1,dsn2' suffix, e.g.
+// 'postgres=primary-dsn;replica=replica1-dsn,replica2-dsn', in which case
+// reads are load-balanced across the replica DSNs via dbresolver while
+// writes stay on the primary.
+//
+// The returned *boundedStmtCache is nil when replicas are configured:
+// dbresolver manages its own per-source connection pools, so there's no
+// single *sql.DB left for us to front with a shared prepared-statement
+// cache.
+func openRawDB(dbval string) (*gorm.DB, *boundedStmtCache, error) {
+	segments := strings.Split(dbval, ";")
+
+	primary, err := parseDSN(segments[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dial, err := dialectorFor(primary)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	db, err := gorm.Open(dial, &gorm.Config{
 		SkipDefaultTransaction: true,
 	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	var replicaDSNs []string
+	for _, seg := range segments[1:] {
+		parsed, err := parseDSN(seg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if parsed.dbtype != "replica" {
+			return nil, nil, fmt.Errorf("unrecognized database string segment %q, expected 'replica=...'", seg)
+		}
+		replicaDSNs = append(replicaDSNs, strings.Split(parsed.params, ",")...)
+	}
+
+	var stmtCache *boundedStmtCache
+
+	if len(replicaDSNs) > 0 {
+		var replicas []gorm.Dialector
+		for _, rdsn := range replicaDSNs {
+			rdial, err := dialectorFor(dsn{dbtype: primary.dbtype, params: rdsn})
+			if err != nil {
+				return nil, nil, err
+			}
+			replicas = append(replicas, rdial)
+		}
+
+		if err := db.Use(dbresolver.Register(dbresolver.Config{
+			Replicas: replicas,
+			Policy:   dbresolver.RandomPolicy{},
+		})); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	sqldb, err := db.DB()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	sqldb.SetMaxIdleConns(80)
 	sqldb.SetMaxOpenConns(99)
 	sqldb.SetConnMaxIdleTime(time.Hour)
 
-	db.AutoMigrate(&Content{})
-	db.AutoMigrate(&Object{})
-	db.AutoMigrate(&ObjRef{})
-	db.AutoMigrate(&Collection{})
-	db.AutoMigrate(&CollectionRef{})
+	if len(replicaDSNs) == 0 {
+		// Front the raw *sql.DB with a bounded, LRU-evicted prepared
+		// statement cache instead of gorm's own PrepareStmt option, which
+		// caches every distinct query forever.
+		stmtCache = newBoundedStmtCache(sqldb)
+		db.ConnPool = stmtCache
+	}
 
-	db.AutoMigrate(&contentDeal{})
-	db.AutoMigrate(&dfeRecord{})
-	db.AutoMigrate(&PieceCommRecord{})
-	db.AutoMigrate(&proposalRecord{})
-	db.AutoMigrate(&retrievalFailureRecord{})
-	db.AutoMigrate(&retrievalSuccessRecord{})
+	return db, stmtCache, nil
+}
 
-	db.AutoMigrate(&minerStorageAsk{})
-	db.AutoMigrate(&storageMiner{})
+// NewDBMgr opens the database described by dbval and makes sure its schema
+// is up to date. If the schema is behind the migrations compiled into this
+// binary, it is brought up to date automatically when autoMigrate is true;
+// otherwise startup fails so that an operator can run `estuary migrate up`
+// (or `migrate status`) deliberately instead of having AutoMigrate silently
+// paper over a destructive change.
+func NewDBMgr(dbval string, autoMigrate bool) (*DBMgr, error) {
+	db, stmtCache, err := openRawDB(dbval)
+	if err != nil {
+		return nil, err
+	}
 
-	db.AutoMigrate(&User{})
-	db.AutoMigrate(&AuthToken{})
-	db.AutoMigrate(&InviteCode{})
+	pending, err := migrations.Pending(db)
+	if err != nil {
+		return nil, err
+	}
 
-	db.AutoMigrate(&Shuttle{})
+	if pending {
+		if !autoMigrate {
+			return nil, fmt.Errorf("database schema is behind this binary's migrations; run 'estuary migrate up' or start with --auto-migrate")
+		}
+		if err := migrations.Up(db); err != nil {
+			return nil, err
+		}
+	}
 
 	var count int64
 	if err := db.Model(&storageMiner{}).Count(&count).Error; err != nil {
@@ -113,58 +239,89 @@ func NewDBMgr(dbval string) (*DBMgr, error) {
 	}
 
 	if count == 0 {
-		// TODO: this could go into its own generic function, potentially batch
-		// these insertions
 		fmt.Println("adding default miner list to database...")
+		miners := make([]storageMiner, 0, len(defaultMiners))
 		for _, m := range defaultMiners {
-			db.Create(&storageMiner{Address: util.DbAddr{Addr: m}})
+			miners = append(miners, storageMiner{Address: util.DbAddr{Addr: m}})
+		}
+		if err := db.Session(&gorm.Session{CreateBatchSize: defaultCreateBatchSize}).Create(&miners).Error; err != nil {
+			return nil, err
 		}
-
 	}
 
-	return &DBMgr{db}, nil
+	return &DBMgr{DB: db, stmtCache: stmtCache}, nil
+}
+
+// withTimeout returns ctx wrapped in a deadline derived from timeout, along
+// with the cancel func the caller must defer. A non-positive timeout is a
+// no-op so query methods can call this unconditionally.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
 }
 
 // USERS
 
-type UsersQuery struct{ DB *gorm.DB }
+type UsersQuery struct {
+	DB      *gorm.DB
+	timeout time.Duration
+}
 
 func NewUsersQuery(db *gorm.DB) *UsersQuery {
 	return &UsersQuery{DB: db.Model(&User{})}
 }
 
-func (q *UsersQuery) WithUsername(username string) *UsersQuery {
+func (q *UsersQuery) WithUsername(username string) UserRepo {
 	q.DB = q.DB.Where("username = ?", username)
 	return q
 }
 
-func (q *UsersQuery) WithID(id uint) *UsersQuery {
+func (q *UsersQuery) WithID(id uint) UserRepo {
 	q.DB = q.DB.Where("id = ?", id)
 	return q
 }
 
-func (q *UsersQuery) Create(user User) error {
-	return q.DB.Create(&user).Error
+// WithTimeout bounds every subsequent terminal call (Get, Count, ...) on
+// this query to d, cancelling the underlying query context if the client
+// that triggered it disconnects or the deadline lapses.
+func (q *UsersQuery) WithTimeout(d time.Duration) UserRepo {
+	q.timeout = d
+	return q
+}
+
+func (q *UsersQuery) Create(ctx context.Context, user User) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Create(&user).Error
 }
 
-func (q *UsersQuery) Get() (User, error) {
+func (q *UsersQuery) Get(ctx context.Context) (User, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
 	var user User
-	if err := q.DB.Take(&user).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Take(&user).Error; err != nil {
 		return User{}, err
 	}
 	return user, nil
 }
 
-func (q *UsersQuery) Count() (int64, error) {
+func (q *UsersQuery) Count(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
 	var count int64
-	if err := q.DB.Count(&count).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Count(&count).Error; err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (q *UsersQuery) Exists() (bool, error) {
-	count, err := q.Count()
+func (q *UsersQuery) Exists(ctx context.Context) (bool, error) {
+	count, err := q.Count(ctx)
 	if err != nil {
 		return false, err
 	}
@@ -172,8 +329,11 @@ func (q *UsersQuery) Exists() (bool, error) {
 }
 
 // Errors if none were deleted
-func (q *UsersQuery) ExpectDelete() error {
-	res := q.DB.Delete(&User{})
+func (q *UsersQuery) ExpectDelete(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	res := q.DB.WithContext(ctx).Delete(&User{})
 	if err := res.Error; err != nil {
 		return err
 	}
@@ -187,79 +347,93 @@ func (q *UsersQuery) ExpectDelete() error {
 
 // AUTH TOKENS
 
-type AuthTokensQuery struct{ DB *gorm.DB }
+type AuthTokensQuery struct {
+	DB      *gorm.DB
+	timeout time.Duration
+}
 
 func NewAuthTokensQuery(db *gorm.DB) *AuthTokensQuery {
 	return &AuthTokensQuery{DB: db.Model(&AuthToken{})}
 }
 
-func (q *AuthTokensQuery) Create(authToken AuthToken) error {
-	return q.DB.Create(&authToken).Error
+func (q *AuthTokensQuery) WithTimeout(d time.Duration) *AuthTokensQuery {
+	q.timeout = d
+	return q
+}
+
+func (q *AuthTokensQuery) Create(ctx context.Context, authToken AuthToken) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Create(&authToken).Error
 }
 
 // CONTENTS
 
-type ContentsQuery struct{ DB *gorm.DB }
+type ContentsQuery struct {
+	DB      *gorm.DB
+	timeout time.Duration
+}
 
 func NewContentsQuery(db *gorm.DB) *ContentsQuery {
 	return &ContentsQuery{DB: db.Model(&Content{})}
 }
 
-func (q *ContentsQuery) WithID(id uint) *ContentsQuery {
+func (q *ContentsQuery) WithID(id uint) ContentRepo {
 	q.DB = q.DB.Where("id = ?", id)
 	return q
 }
 
-func (q *ContentsQuery) WithActive(active bool) *ContentsQuery {
-	if active {
-		q.DB = q.DB.Where("active")
-	} else {
-		q.DB = q.DB.Where("NOT active")
-	}
+func (q *ContentsQuery) WithActive(active bool) ContentRepo {
+	q.DB = q.DB.Where(boolExpr(q.DB, "active", active))
 	return q
 }
 
-func (q *ContentsQuery) WithUserID(userID uint) *ContentsQuery {
+func (q *ContentsQuery) WithUserID(userID uint) ContentRepo {
 	q.DB = q.DB.Where("user_id = ?", userID)
 	return q
 }
 
-func (q *ContentsQuery) WithCid(cid gocid.Cid) *ContentsQuery {
+func (q *ContentsQuery) WithCid(cid gocid.Cid) ContentRepo {
 	q.DB = q.DB.Where("cid = ?", cidToBytes(cid))
 	return q
 }
 
-func (q *ContentsQuery) WithCids(cids []gocid.Cid) *ContentsQuery {
+func (q *ContentsQuery) WithCids(cids []gocid.Cid) ContentRepo {
 	q.DB = q.DB.Where("cid IN ?", cidsToBytes(cids))
 	return q
 }
 
-func (q *ContentsQuery) WithAggregate(aggregate bool) *ContentsQuery {
-	if aggregate {
-		q.DB = q.DB.Where("aggregate")
-	} else {
-		q.DB = q.DB.Where("NOT aggregate")
-	}
+func (q *ContentsQuery) WithAggregate(aggregate bool) ContentRepo {
+	q.DB = q.DB.Where(boolExpr(q.DB, "aggregate", aggregate))
 	return q
 }
 
-func (q *ContentsQuery) WithAggregatedIn(contentID uint) *ContentsQuery {
+func (q *ContentsQuery) WithAggregatedIn(contentID uint) ContentRepo {
 	q.DB = q.DB.Where("aggregated_in = ?", contentID)
 	return q
 }
 
-func (q *ContentsQuery) Limit(limit int) *ContentsQuery {
+func (q *ContentsQuery) Limit(limit int) ContentRepo {
 	q.DB = q.DB.Limit(limit)
 	return q
 }
 
-func (q *ContentsQuery) Offset(offset int) *ContentsQuery {
+func (q *ContentsQuery) Offset(offset int) ContentRepo {
 	q.DB = q.DB.Offset(offset)
 	return q
 }
 
+// WithTimeout bounds every subsequent terminal call (Get, GetAll, Count,
+// ...) on this query to d, cancelling the underlying query context if the
+// client that triggered it disconnects or the deadline lapses.
+func (q *ContentsQuery) WithTimeout(d time.Duration) ContentRepo {
+	q.timeout = d
+	return q
+}
+
 // TODO: order functions can probably be simplified
-func (q *ContentsQuery) OrderByCreationDate(order DBSortOrder) *ContentsQuery {
+func (q *ContentsQuery) OrderByCreationDate(order DBSortOrder) ContentRepo {
 	if order == OrderDescending {
 		q.DB = q.DB.Order("created_at DESC")
 	} else {
@@ -268,7 +442,7 @@ func (q *ContentsQuery) OrderByCreationDate(order DBSortOrder) *ContentsQuery {
 	return q
 }
 
-func (q *ContentsQuery) OrderByID(order DBSortOrder) *ContentsQuery {
+func (q *ContentsQuery) OrderByID(order DBSortOrder) ContentRepo {
 	if order == OrderDescending {
 		q.DB = q.DB.Order("id DESC")
 	} else {
@@ -277,41 +451,103 @@ func (q *ContentsQuery) OrderByID(order DBSortOrder) *ContentsQuery {
 	return q
 }
 
-func (q *ContentsQuery) CreateAll(contents []Content) error {
-	return q.DB.Create(&contents).Error
+func (q *ContentsQuery) OrderBySize(order DBSortOrder) ContentRepo {
+	if order == OrderDescending {
+		q.DB = q.DB.Order("size DESC")
+	} else {
+		q.DB = q.DB.Order("size ASC")
+	}
+	return q
+}
+
+func (q *ContentsQuery) CreateAll(ctx context.Context, contents []Content) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Create(&contents).Error
 }
 
-func (q *ContentsQuery) Get() (Content, error) {
+// CreateInBatches inserts contents in chunks of size instead of gorm's
+// default batch size, so a pin burst large enough to exceed postgres'
+// 65535 bind parameter limit doesn't fail outright.
+func (q *ContentsQuery) CreateInBatches(ctx context.Context, contents []Content, size int) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Session(&gorm.Session{CreateBatchSize: size}).Create(&contents).Error
+}
+
+// Search runs filter against the contents table and returns every matching
+// row. Unlike the WithX builder chain above, filter can express OR, NOT,
+// and arbitrary comparisons: it's rendered to SQL via squirrel and run
+// through gorm.Raw rather than chained onto q.DB with Where.
+func (q *ContentsQuery) Search(ctx context.Context, filter ContentFilter) ([]Content, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	builder := sq.Select("*").From("contents").PlaceholderFormat(placeholderFormatFor(q.DB))
+	if filter != nil {
+		builder = builder.Where(filter.toSquirrel())
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var contents []Content
+	if err := q.DB.WithContext(ctx).Raw(query, args...).Scan(&contents).Error; err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+func (q *ContentsQuery) Get(ctx context.Context) (Content, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
 	var content Content
-	if err := q.DB.Take(&content).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Take(&content).Error; err != nil {
 		return Content{}, err
 	}
 	return content, nil
 }
 
-func (q *ContentsQuery) GetAll() ([]Content, error) {
+func (q *ContentsQuery) GetAll(ctx context.Context) ([]Content, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
 	var contents []Content
-	if err := q.DB.Find(&contents).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Find(&contents).Error; err != nil {
 		return nil, nil
 	}
 	return contents, nil
 }
 
-func (q *ContentsQuery) Count() (int64, error) {
+func (q *ContentsQuery) Count(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
 	var count int64
-	if err := q.DB.Count(&count).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Count(&count).Error; err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (q *ContentsQuery) Delete() error {
-	return q.DB.Delete(&Content{}).Error
+func (q *ContentsQuery) Delete(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Delete(&Content{}).Error
 }
 
 // OBJECTS
 
-type ObjectsQuery struct{ DB *gorm.DB }
+type ObjectsQuery struct {
+	DB      *gorm.DB
+	timeout time.Duration
+}
 
 func NewObjectsQuery(db *gorm.DB) *ObjectsQuery {
 	return &ObjectsQuery{DB: db.Model(&Object{})}
@@ -322,33 +558,73 @@ func (q *ObjectsQuery) WithCid(cid gocid.Cid) *ObjectsQuery {
 	return q
 }
 
-func (q *ObjectsQuery) Count() (int64, error) {
+func (q *ObjectsQuery) WithTimeout(d time.Duration) *ObjectsQuery {
+	q.timeout = d
+	return q
+}
+
+func (q *ObjectsQuery) Count(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
 	var count int64
-	if err := q.DB.Count(&count).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Count(&count).Error; err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (q *ObjectsQuery) Exists() (bool, error) {
-	count, err := q.Count()
+func (q *ObjectsQuery) Exists(ctx context.Context) (bool, error) {
+	count, err := q.Count(ctx)
 	if err != nil {
 		return false, err
 	}
 	return count > 0, nil
 }
 
+// CreateInBatches inserts objects in chunks of size instead of gorm's
+// default batch size, the same way ContentsQuery.CreateInBatches does.
+func (q *ObjectsQuery) CreateInBatches(ctx context.Context, objects []Object, size int) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Session(&gorm.Session{CreateBatchSize: size}).Create(&objects).Error
+}
+
 // TODO: simplify by using other abstracted functions instead
-func (q *ObjectsQuery) DeleteUnreferenced(ids []uint) error {
-	return q.DB.Where(
-		"(?) = 0 AND id in ?",
-		q.DB.Model(&ObjRef{}).Where("object = objects.id").Select("count(1)"), ids,
-	).Delete(Object{}).Error
+func (q *ObjectsQuery) DeleteUnreferenced(ctx context.Context, ids []uint) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	db := q.DB.WithContext(ctx)
+
+	unreferenced := db.Model(&ObjRef{}).Where("object = objects.id").Select("count(1)")
+
+	if dialectOf(db) == dialectMySQL {
+		// MySQL rejects a DELETE whose WHERE clause subquery is correlated
+		// against the very table being deleted from ("You can't specify
+		// target table 'objects' for update in FROM clause"). Wrapping the
+		// same correlated subquery in a derived table sidesteps that
+		// restriction - the outer IN now references the derived table, not
+		// objects directly - while keeping the whole operation one atomic
+		// statement. A separate SELECT-then-DELETE pair would leave a
+		// window for a concurrent ObjRef insert to re-reference an id
+		// between the two statements.
+		return db.Exec(
+			"DELETE FROM objects WHERE id IN (SELECT id FROM (SELECT id FROM objects WHERE (?) = 0 AND id IN ?) AS unreferenced_objects)",
+			unreferenced, ids,
+		).Error
+	}
+
+	return db.Where("(?) = 0 AND id in ?", unreferenced, ids).Delete(Object{}).Error
 }
 
 // OBJ REFS
 
-type ObjRefsQuery struct{ DB *gorm.DB }
+type ObjRefsQuery struct {
+	DB      *gorm.DB
+	timeout time.Duration
+}
 
 func NewObjRefsQuery(db *gorm.DB) *ObjRefsQuery {
 	return &ObjRefsQuery{DB: db.Model(&ObjRef{})}
@@ -359,31 +635,82 @@ func (q *ObjRefsQuery) WithPinID(pinID uint) *ObjRefsQuery {
 	return q
 }
 
-func (q *ObjRefsQuery) Delete() error {
-	return q.DB.Delete(&ObjRef{}).Error
+func (q *ObjRefsQuery) WithTimeout(d time.Duration) *ObjRefsQuery {
+	q.timeout = d
+	return q
+}
+
+func (q *ObjRefsQuery) Delete(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Delete(&ObjRef{}).Error
+}
+
+// CreateInBatches inserts refs in chunks of size instead of gorm's default
+// batch size, the same way ContentsQuery.CreateInBatches does.
+func (q *ObjRefsQuery) CreateInBatches(ctx context.Context, refs []ObjRef, size int) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Session(&gorm.Session{CreateBatchSize: size}).Create(&refs).Error
 }
 
 // DEALS
 
-type DealsQuery struct{ DB *gorm.DB }
+type DealsQuery struct {
+	DB      *gorm.DB
+	timeout time.Duration
+}
 
 func NewDealsQuery(db *gorm.DB) *DealsQuery {
 	return &DealsQuery{DB: db.Model(&contentDeal{})}
 }
 
-func (q *DealsQuery) WithContentID(contentID uint) *DealsQuery {
+func (q *DealsQuery) WithContentID(contentID uint) DealRepo {
 	q.DB = q.DB.Where("content = ?", contentID)
 	return q
 }
 
-func (q *DealsQuery) WithContentIDs(contentIDs []uint) *DealsQuery {
+func (q *DealsQuery) WithContentIDs(contentIDs []uint) DealRepo {
 	q.DB = q.DB.Where("content IN ?", contentIDs)
 	return q
 }
 
-func (q *DealsQuery) GetAll() ([]contentDeal, error) {
+func (q *DealsQuery) WithTimeout(d time.Duration) DealRepo {
+	q.timeout = d
+	return q
+}
+
+func (q *DealsQuery) GetAll(ctx context.Context) ([]contentDeal, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	var deals []contentDeal
+	if err := q.DB.WithContext(ctx).Find(&deals).Error; err != nil {
+		return nil, err
+	}
+	return deals, nil
+}
+
+// Search runs filter against the deals table and returns every matching
+// row, the same way ContentsQuery.Search does.
+func (q *DealsQuery) Search(ctx context.Context, filter ContentFilter) ([]contentDeal, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	builder := sq.Select("*").From("content_deals").PlaceholderFormat(placeholderFormatFor(q.DB))
+	if filter != nil {
+		builder = builder.Where(filter.toSquirrel())
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
 	var deals []contentDeal
-	if err := q.DB.Find(&deals).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Raw(query, args...).Scan(&deals).Error; err != nil {
 		return nil, err
 	}
 	return deals, nil
@@ -391,7 +718,10 @@ func (q *DealsQuery) GetAll() ([]contentDeal, error) {
 
 // COLLECTIONS
 
-type CollectionsQuery struct{ DB *gorm.DB }
+type CollectionsQuery struct {
+	DB      *gorm.DB
+	timeout time.Duration
+}
 
 func NewCollectionsQuery(db *gorm.DB) *CollectionsQuery {
 	return &CollectionsQuery{DB: db.Model(&Collection{})}
@@ -407,9 +737,17 @@ func (q *CollectionsQuery) WithUserID(userID uint) *CollectionsQuery {
 	return q
 }
 
-func (q *CollectionsQuery) Get() (Collection, error) {
+func (q *CollectionsQuery) WithTimeout(d time.Duration) *CollectionsQuery {
+	q.timeout = d
+	return q
+}
+
+func (q *CollectionsQuery) Get(ctx context.Context) (Collection, error) {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
 	var collection Collection
-	if err := q.DB.Take(&collection).Error; err != nil {
+	if err := q.DB.WithContext(ctx).Take(&collection).Error; err != nil {
 		return Collection{}, err
 	}
 
@@ -418,14 +756,25 @@ func (q *CollectionsQuery) Get() (Collection, error) {
 
 // COLLECTION REFS
 
-type CollectionRefsQuery struct{ DB *gorm.DB }
+type CollectionRefsQuery struct {
+	DB      *gorm.DB
+	timeout time.Duration
+}
 
 func NewCollectionRefsQuery(db *gorm.DB) *CollectionRefsQuery {
 	return &CollectionRefsQuery{DB: db.Model(&CollectionRef{})}
 }
 
-func (q *CollectionRefsQuery) Create(collectionRef CollectionRef) error {
-	return q.DB.Create(&collectionRef).Error
+func (q *CollectionRefsQuery) WithTimeout(d time.Duration) *CollectionRefsQuery {
+	q.timeout = d
+	return q
+}
+
+func (q *CollectionRefsQuery) Create(ctx context.Context, collectionRef CollectionRef) error {
+	ctx, cancel := withTimeout(ctx, q.timeout)
+	defer cancel()
+
+	return q.DB.WithContext(ctx).Create(&collectionRef).Error
 }
 
 // HELPER FUNCTIONS
@@ -467,4 +816,4 @@ func cidsToBytes(cids []gocid.Cid) [][]byte {
 	}
 
 	return bytesList
-}
\ No newline at end of file
+}
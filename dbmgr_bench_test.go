@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const benchRowCount = 100_000
+
+// newBenchDB opens a fresh on-disk sqlite DB migrated for Object/ObjRef, so
+// the benchmarks below see the same per-statement overhead a real
+// connection has instead of :memory:'s single-connection fast path.
+func newBenchDB(b *testing.B) *gorm.DB {
+	b.Helper()
+
+	db, err := gorm.Open(sqlite.Open(b.TempDir()+"/bench.db"), &gorm.Config{})
+	if err != nil {
+		b.Fatalf("opening sqlite: %v", err)
+	}
+	for _, model := range []interface{}{&Object{}, &ObjRef{}} {
+		if err := db.AutoMigrate(model); err != nil {
+			b.Fatalf("AutoMigrate(%T): %v", model, err)
+		}
+	}
+	return db
+}
+
+// BenchmarkCreateObjectsDefault inserts benchRowCount Objects one at a time
+// via plain Create, the baseline defaultMiners-style loop this commit
+// replaces for bulk ingest paths.
+func BenchmarkCreateObjectsDefault(b *testing.B) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		db := newBenchDB(b)
+		for j := 0; j < benchRowCount; j++ {
+			if err := db.WithContext(ctx).Create(&Object{}).Error; err != nil {
+				b.Fatalf("Create: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkCreateObjectsInBatches inserts the same benchRowCount Objects
+// through ObjectsQuery.CreateInBatches, for comparing against
+// BenchmarkCreateObjectsDefault's throughput.
+func BenchmarkCreateObjectsInBatches(b *testing.B) {
+	ctx := context.Background()
+	objects := make([]Object, benchRowCount)
+
+	for i := 0; i < b.N; i++ {
+		db := newBenchDB(b)
+		q := NewObjectsQuery(db)
+		if err := q.CreateInBatches(ctx, objects, defaultCreateBatchSize); err != nil {
+			b.Fatalf("CreateInBatches: %v", err)
+		}
+	}
+}
+
+// BenchmarkCreateObjRefsInBatches inserts benchRowCount ObjRefs through
+// ObjRefsQuery.CreateInBatches, the other hot path CreateInBatches was
+// added for.
+func BenchmarkCreateObjRefsInBatches(b *testing.B) {
+	ctx := context.Background()
+	refs := make([]ObjRef, benchRowCount)
+
+	for i := 0; i < b.N; i++ {
+		db := newBenchDB(b)
+		q := NewObjRefsQuery(db)
+		if err := q.CreateInBatches(ctx, refs, defaultCreateBatchSize); err != nil {
+			b.Fatalf("CreateInBatches: %v", err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	gocid "github.com/ipfs/go-cid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// newGormRepos opens a fresh in-memory sqlite DB and returns constructors
+// for UserRepo/ContentRepo backed by it, so the contract tests below can run
+// the exact same assertions against *UsersQuery/*ContentsQuery and their mem
+// equivalents. Each With* call adds to the repo it is called on, so every
+// query chain starts from a fresh repo.
+func newGormRepos(t *testing.T) (func() UserRepo, func() ContentRepo) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+	for _, model := range []interface{}{&User{}, &Content{}} {
+		if err := db.AutoMigrate(model); err != nil {
+			t.Fatalf("AutoMigrate(%T): %v", model, err)
+		}
+	}
+
+	return func() UserRepo { return NewUsersQuery(db) }, func() ContentRepo { return NewContentsQuery(db) }
+}
+
+// userRepoBackends returns a UserRepo constructor for each implementation
+// that's supposed to behave identically; repos from one constructor share
+// the same stored rows.
+func userRepoBackends(t *testing.T) map[string]func() UserRepo {
+	t.Helper()
+
+	gormUsers, _ := newGormRepos(t)
+	return map[string]func() UserRepo{
+		"gorm": gormUsers,
+		"mem":  NewMemDBMgr().Users,
+	}
+}
+
+// contentRepoBackends returns a ContentRepo constructor for each
+// implementation that's supposed to behave identically; repos from one
+// constructor share the same stored rows.
+func contentRepoBackends(t *testing.T) map[string]func() ContentRepo {
+	t.Helper()
+
+	_, gormContents := newGormRepos(t)
+	return map[string]func() ContentRepo{
+		"gorm": gormContents,
+		"mem":  NewMemDBMgr().Contents,
+	}
+}
+
+// TestUserRepoExpectDeleteContract checks that ExpectDelete returns
+// gorm.ErrRecordNotFound when nothing matched, on both backends.
+func TestUserRepoExpectDeleteContract(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newRepo := range userRepoBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := newRepo().WithUsername("nobody").ExpectDelete(ctx); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("ExpectDelete on no match: got %v, want gorm.ErrRecordNotFound", err)
+			}
+
+			if err := newRepo().Create(ctx, User{Username: "alice"}); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if err := newRepo().WithUsername("alice").ExpectDelete(ctx); err != nil {
+				t.Fatalf("ExpectDelete on match: %v", err)
+			}
+			if err := newRepo().WithUsername("alice").ExpectDelete(ctx); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("ExpectDelete after delete: got %v, want gorm.ErrRecordNotFound", err)
+			}
+		})
+	}
+}
+
+// TestContentRepoWithActiveContract checks that WithActive(false) excludes
+// active rows instead of being treated as an unset filter, on both
+// backends.
+func TestContentRepoWithActiveContract(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newRepo := range contentRepoBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := newRepo().CreateAll(ctx, []Content{{Active: true}, {Active: false}}); err != nil {
+				t.Fatalf("CreateAll: %v", err)
+			}
+
+			activeCount, err := newRepo().WithActive(true).Count(ctx)
+			if err != nil {
+				t.Fatalf("Count(active): %v", err)
+			}
+			if activeCount != 1 {
+				t.Fatalf("WithActive(true) count = %d, want 1", activeCount)
+			}
+
+			inactiveCount, err := newRepo().WithActive(false).Count(ctx)
+			if err != nil {
+				t.Fatalf("Count(inactive): %v", err)
+			}
+			if inactiveCount != 1 {
+				t.Fatalf("WithActive(false) count = %d, want 1", inactiveCount)
+			}
+		})
+	}
+}
+
+// TestContentRepoWithAggregateContract checks that WithAggregate(false)
+// excludes aggregate rows instead of being treated as an unset filter, on
+// both backends.
+func TestContentRepoWithAggregateContract(t *testing.T) {
+	ctx := context.Background()
+
+	for name, newRepo := range contentRepoBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := newRepo().CreateAll(ctx, []Content{{Aggregate: true}, {Aggregate: false}}); err != nil {
+				t.Fatalf("CreateAll: %v", err)
+			}
+
+			aggregateCount, err := newRepo().WithAggregate(true).Count(ctx)
+			if err != nil {
+				t.Fatalf("Count(aggregate): %v", err)
+			}
+			if aggregateCount != 1 {
+				t.Fatalf("WithAggregate(true) count = %d, want 1", aggregateCount)
+			}
+
+			nonAggregateCount, err := newRepo().WithAggregate(false).Count(ctx)
+			if err != nil {
+				t.Fatalf("Count(non-aggregate): %v", err)
+			}
+			if nonAggregateCount != 1 {
+				t.Fatalf("WithAggregate(false) count = %d, want 1", nonAggregateCount)
+			}
+		})
+	}
+}
+
+// TestContentRepoSearchContract checks that a Cmp("cid", "=", ...) filter
+// matches the same row on both backends. Search forwards the filter's
+// value straight through to the real table's cid column, which stores
+// cidToBytes(cid) rather than the CID's string form, so a portable filter
+// has to pass cidToBytes(cid) and both backends have to resolve "cid" to
+// the same bytes for it to match on both.
+func TestContentRepoSearchContract(t *testing.T) {
+	ctx := context.Background()
+
+	wantCid, err := gocid.Decode("bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi")
+	if err != nil {
+		t.Fatalf("decoding test cid: %v", err)
+	}
+	otherCid, err := gocid.Decode("bafybeibtj4n6qiqfex4ofd3jfqb76zjly2zihduq4lvkkajzlmjsdm4aom")
+	if err != nil {
+		t.Fatalf("decoding other test cid: %v", err)
+	}
+
+	for name, newRepo := range contentRepoBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := newRepo().CreateAll(ctx, []Content{{Cid: wantCid}, {Cid: otherCid}}); err != nil {
+				t.Fatalf("CreateAll: %v", err)
+			}
+
+			matches, err := newRepo().Search(ctx, Cmp("cid", "=", cidToBytes(wantCid)))
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if len(matches) != 1 {
+				t.Fatalf("Search(cid = wantCid) returned %d rows, want 1", len(matches))
+			}
+			if !matches[0].Cid.Equals(wantCid) {
+				t.Fatalf("Search(cid = wantCid) returned cid %s, want %s", matches[0].Cid, wantCid)
+			}
+		})
+	}
+}
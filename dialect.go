@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// sqlDialect identifies which SQL dialect a *gorm.DB is speaking, so that
+// hand-written query fragments can stay portable across sqlite,
+// postgres/cockroach, and mysql.
+type sqlDialect string
+
+const (
+	dialectSQLite   sqlDialect = "sqlite"
+	dialectPostgres sqlDialect = "postgres"
+	dialectMySQL    sqlDialect = "mysql"
+)
+
+func dialectOf(db *gorm.DB) sqlDialect {
+	switch db.Dialector.Name() {
+	case "mysql":
+		return dialectMySQL
+	case "postgres":
+		return dialectPostgres
+	default:
+		return dialectSQLite
+	}
+}
+
+// boolExpr renders a WHERE fragment testing column's truthiness. Sqlite and
+// postgres both accept a bare boolean column (or its negation) as a
+// predicate, but MySQL has no native boolean type - columns declared
+// `boolean` are really TINYINT(1), and `WHERE active` only works there by
+// accident of the column happening to be an integer. Compare explicitly so
+// the same query works the same way on all three backends.
+func boolExpr(db *gorm.DB, column string, value bool) string {
+	if dialectOf(db) == dialectMySQL {
+		if value {
+			return fmt.Sprintf("%s = 1", column)
+		}
+		return fmt.Sprintf("%s = 0", column)
+	}
+
+	if value {
+		return column
+	}
+	return "NOT " + column
+}
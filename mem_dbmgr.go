@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gocid "github.com/ipfs/go-cid"
+	"gorm.io/gorm"
+)
+
+// UserRepo, ContentRepo, and DealRepo are the persistence operations the
+// rest of Estuary needs from users/contents/deals, independent of whether
+// they're backed by gorm or an in-memory fake. *UsersQuery, *ContentsQuery,
+// and *DealsQuery satisfy these against a real database; memUsersQuery,
+// memContentsQuery, and memDealsQuery (returned by NewMemDBMgr) satisfy them
+// against an in-process map, so HTTP handlers built against the interfaces
+// are testable without spinning up sqlite.
+type UserRepo interface {
+	WithUsername(username string) UserRepo
+	WithID(id uint) UserRepo
+	WithTimeout(d time.Duration) UserRepo
+
+	Create(ctx context.Context, user User) error
+	Get(ctx context.Context) (User, error)
+	Count(ctx context.Context) (int64, error)
+	Exists(ctx context.Context) (bool, error)
+	ExpectDelete(ctx context.Context) error
+}
+
+type ContentRepo interface {
+	WithID(id uint) ContentRepo
+	WithActive(active bool) ContentRepo
+	WithUserID(userID uint) ContentRepo
+	WithCid(cid gocid.Cid) ContentRepo
+	WithCids(cids []gocid.Cid) ContentRepo
+	WithAggregate(aggregate bool) ContentRepo
+	WithAggregatedIn(contentID uint) ContentRepo
+	Limit(limit int) ContentRepo
+	Offset(offset int) ContentRepo
+	OrderByCreationDate(order DBSortOrder) ContentRepo
+	OrderByID(order DBSortOrder) ContentRepo
+	OrderBySize(order DBSortOrder) ContentRepo
+	WithTimeout(d time.Duration) ContentRepo
+
+	CreateAll(ctx context.Context, contents []Content) error
+	Get(ctx context.Context) (Content, error)
+	GetAll(ctx context.Context) ([]Content, error)
+	Count(ctx context.Context) (int64, error)
+	Delete(ctx context.Context) error
+	Search(ctx context.Context, filter ContentFilter) ([]Content, error)
+}
+
+type DealRepo interface {
+	WithContentID(contentID uint) DealRepo
+	WithContentIDs(contentIDs []uint) DealRepo
+	WithTimeout(d time.Duration) DealRepo
+
+	GetAll(ctx context.Context) ([]contentDeal, error)
+	Search(ctx context.Context, filter ContentFilter) ([]contentDeal, error)
+}
+
+var (
+	_ UserRepo    = (*UsersQuery)(nil)
+	_ ContentRepo = (*ContentsQuery)(nil)
+	_ DealRepo    = (*DealsQuery)(nil)
+)
+
+// memStore is the map-backed state shared by every repo a MemDBMgr hands
+// out, so that e.g. a content created through one Contents() call is
+// visible to a later one, the same as two queries sharing a *gorm.DB would
+// see the same rows.
+type memStore struct {
+	mu sync.Mutex
+
+	users         []User
+	nextUserID    uint
+	contents      []Content
+	nextContentID uint
+	deals         []contentDeal
+}
+
+// MemDBMgr is an in-memory stand-in for DBMgr, for unit tests that want to
+// exercise handlers built against UserRepo/ContentRepo/DealRepo without a
+// real database.
+type MemDBMgr struct{ store *memStore }
+
+// NewMemDBMgr returns an empty in-memory DBMgr equivalent.
+func NewMemDBMgr() *MemDBMgr {
+	return &MemDBMgr{store: &memStore{}}
+}
+
+func (mgr *MemDBMgr) Users() UserRepo {
+	return &memUsersQuery{store: mgr.store}
+}
+
+func (mgr *MemDBMgr) Contents() ContentRepo {
+	return &memContentsQuery{store: mgr.store}
+}
+
+func (mgr *MemDBMgr) Deals() DealRepo {
+	return &memDealsQuery{store: mgr.store}
+}
+
+// USERS
+
+type memUsersQuery struct {
+	store   *memStore
+	filters []func(User) bool
+	timeout time.Duration
+}
+
+func (q *memUsersQuery) WithUsername(username string) UserRepo {
+	q.filters = append(q.filters, func(u User) bool { return u.Username == username })
+	return q
+}
+
+func (q *memUsersQuery) WithID(id uint) UserRepo {
+	q.filters = append(q.filters, func(u User) bool { return u.ID == id })
+	return q
+}
+
+func (q *memUsersQuery) WithTimeout(d time.Duration) UserRepo {
+	q.timeout = d
+	return q
+}
+
+func (q *memUsersQuery) matching(users []User) []User {
+	var out []User
+	for _, u := range users {
+		if matchesAll(u, q.filters) {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func (q *memUsersQuery) Create(ctx context.Context, user User) error {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	q.store.nextUserID++
+	user.ID = q.store.nextUserID
+	q.store.users = append(q.store.users, user)
+	return nil
+}
+
+func (q *memUsersQuery) Get(ctx context.Context) (User, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	matches := q.matching(q.store.users)
+	if len(matches) == 0 {
+		return User{}, gorm.ErrRecordNotFound
+	}
+	return matches[0], nil
+}
+
+func (q *memUsersQuery) Count(ctx context.Context) (int64, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	return int64(len(q.matching(q.store.users))), nil
+}
+
+func (q *memUsersQuery) Exists(ctx context.Context) (bool, error) {
+	count, err := q.Count(ctx)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ExpectDelete mirrors UsersQuery.ExpectDelete: it errors with
+// gorm.ErrRecordNotFound if nothing matched, the same contract a caller
+// gets from the real database.
+func (q *memUsersQuery) ExpectDelete(ctx context.Context) error {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var kept []User
+	deleted := 0
+	for _, u := range q.store.users {
+		if matchesAll(u, q.filters) {
+			deleted++
+			continue
+		}
+		kept = append(kept, u)
+	}
+	q.store.users = kept
+
+	if deleted == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CONTENTS
+
+type memContentsQuery struct {
+	store   *memStore
+	filters []func(Content) bool
+	order   func(a, b Content) bool
+	limit   int
+	offset  int
+	timeout time.Duration
+}
+
+func (q *memContentsQuery) WithID(id uint) ContentRepo {
+	q.filters = append(q.filters, func(c Content) bool { return c.ID == id })
+	return q
+}
+
+func (q *memContentsQuery) WithActive(active bool) ContentRepo {
+	q.filters = append(q.filters, func(c Content) bool { return c.Active == active })
+	return q
+}
+
+func (q *memContentsQuery) WithUserID(userID uint) ContentRepo {
+	q.filters = append(q.filters, func(c Content) bool { return c.UserID == userID })
+	return q
+}
+
+func (q *memContentsQuery) WithCid(cid gocid.Cid) ContentRepo {
+	q.filters = append(q.filters, func(c Content) bool { return c.Cid.Equals(cid) })
+	return q
+}
+
+func (q *memContentsQuery) WithCids(cids []gocid.Cid) ContentRepo {
+	q.filters = append(q.filters, func(c Content) bool {
+		for _, cid := range cids {
+			if c.Cid.Equals(cid) {
+				return true
+			}
+		}
+		return false
+	})
+	return q
+}
+
+func (q *memContentsQuery) WithAggregate(aggregate bool) ContentRepo {
+	q.filters = append(q.filters, func(c Content) bool { return c.Aggregate == aggregate })
+	return q
+}
+
+func (q *memContentsQuery) WithAggregatedIn(contentID uint) ContentRepo {
+	q.filters = append(q.filters, func(c Content) bool { return c.AggregatedIn == contentID })
+	return q
+}
+
+func (q *memContentsQuery) Limit(limit int) ContentRepo {
+	q.limit = limit
+	return q
+}
+
+func (q *memContentsQuery) Offset(offset int) ContentRepo {
+	q.offset = offset
+	return q
+}
+
+func (q *memContentsQuery) OrderByCreationDate(order DBSortOrder) ContentRepo {
+	if order == OrderDescending {
+		q.order = func(a, b Content) bool { return a.CreatedAt.After(b.CreatedAt) }
+	} else {
+		q.order = func(a, b Content) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	}
+	return q
+}
+
+func (q *memContentsQuery) OrderByID(order DBSortOrder) ContentRepo {
+	if order == OrderDescending {
+		q.order = func(a, b Content) bool { return a.ID > b.ID }
+	} else {
+		q.order = func(a, b Content) bool { return a.ID < b.ID }
+	}
+	return q
+}
+
+func (q *memContentsQuery) OrderBySize(order DBSortOrder) ContentRepo {
+	if order == OrderDescending {
+		q.order = func(a, b Content) bool { return a.Size > b.Size }
+	} else {
+		q.order = func(a, b Content) bool { return a.Size < b.Size }
+	}
+	return q
+}
+
+func (q *memContentsQuery) WithTimeout(d time.Duration) ContentRepo {
+	q.timeout = d
+	return q
+}
+
+func (q *memContentsQuery) CreateAll(ctx context.Context, contents []Content) error {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	for _, c := range contents {
+		q.store.nextContentID++
+		c.ID = q.store.nextContentID
+		q.store.contents = append(q.store.contents, c)
+	}
+	return nil
+}
+
+func (q *memContentsQuery) all() []Content {
+	matches := make([]Content, 0, len(q.store.contents))
+	for _, c := range q.store.contents {
+		if matchesAll(c, q.filters) {
+			matches = append(matches, c)
+		}
+	}
+
+	if q.order != nil {
+		sortContents(matches, q.order)
+	}
+
+	if q.offset > 0 && q.offset < len(matches) {
+		matches = matches[q.offset:]
+	} else if q.offset >= len(matches) {
+		matches = nil
+	}
+
+	if q.limit > 0 && q.limit < len(matches) {
+		matches = matches[:q.limit]
+	}
+
+	return matches
+}
+
+func (q *memContentsQuery) Get(ctx context.Context) (Content, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	matches := q.all()
+	if len(matches) == 0 {
+		return Content{}, gorm.ErrRecordNotFound
+	}
+	return matches[0], nil
+}
+
+func (q *memContentsQuery) GetAll(ctx context.Context) ([]Content, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	return q.all(), nil
+}
+
+func (q *memContentsQuery) Count(ctx context.Context) (int64, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	return int64(len(q.all())), nil
+}
+
+func (q *memContentsQuery) Delete(ctx context.Context) error {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var kept []Content
+	for _, c := range q.store.contents {
+		if !matchesAll(c, q.filters) {
+			kept = append(kept, c)
+		}
+	}
+	q.store.contents = kept
+	return nil
+}
+
+// Search is the in-memory analogue of ContentsQuery.Search: instead of
+// rendering filter to SQL via squirrel, it evaluates the same
+// ContentFilter tree directly against each row's columns.
+func (q *memContentsQuery) Search(ctx context.Context, filter ContentFilter) ([]Content, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var out []Content
+	for _, c := range q.store.contents {
+		if filter == nil || evalFilter(filter, contentColumn(c)) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// DEALS
+
+type memDealsQuery struct {
+	store   *memStore
+	filters []func(contentDeal) bool
+	timeout time.Duration
+}
+
+func (q *memDealsQuery) WithContentID(contentID uint) DealRepo {
+	q.filters = append(q.filters, func(d contentDeal) bool { return d.Content == contentID })
+	return q
+}
+
+func (q *memDealsQuery) WithContentIDs(contentIDs []uint) DealRepo {
+	q.filters = append(q.filters, func(d contentDeal) bool {
+		for _, id := range contentIDs {
+			if d.Content == id {
+				return true
+			}
+		}
+		return false
+	})
+	return q
+}
+
+func (q *memDealsQuery) WithTimeout(d time.Duration) DealRepo {
+	q.timeout = d
+	return q
+}
+
+func (q *memDealsQuery) GetAll(ctx context.Context) ([]contentDeal, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var out []contentDeal
+	for _, d := range q.store.deals {
+		if matchesAll(d, q.filters) {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// Search is the in-memory analogue of DealsQuery.Search, evaluating the
+// ContentFilter tree directly against each deal's columns instead of
+// rendering it to SQL.
+func (q *memDealsQuery) Search(ctx context.Context, filter ContentFilter) ([]contentDeal, error) {
+	q.store.mu.Lock()
+	defer q.store.mu.Unlock()
+
+	var out []contentDeal
+	for _, d := range q.store.deals {
+		if filter == nil || evalFilter(filter, dealColumn(d)) {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// HELPERS
+
+func matchesAll[T any](v T, filters []func(T) bool) bool {
+	for _, f := range filters {
+		if !f(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortContents(contents []Content, less func(a, b Content) bool) {
+	for i := 1; i < len(contents); i++ {
+		for j := i; j > 0 && less(contents[j], contents[j-1]); j-- {
+			contents[j], contents[j-1] = contents[j-1], contents[j]
+		}
+	}
+}
+
+// contentColumn returns a column lookup for c matching the names
+// ContentsQuery.Search's squirrel builder selects from the contents table,
+// for memContentsQuery.Search to evaluate a ContentFilter against.
+func contentColumn(c Content) func(column string) (interface{}, bool) {
+	return func(column string) (interface{}, bool) {
+		switch column {
+		case "id":
+			return c.ID, true
+		case "active":
+			return c.Active, true
+		case "user_id":
+			return c.UserID, true
+		case "cid":
+			// The real contents table stores cidToBytes(cid), and
+			// ContentsQuery.Search forwards a Cmp("cid", ...) filter's
+			// value straight through to that comparison with no
+			// transformation of its own - so a portable filter must
+			// already be carrying bytes, and this has to resolve "cid" to
+			// the same bytes to match it.
+			return cidToBytes(c.Cid), true
+		case "aggregate":
+			return c.Aggregate, true
+		case "aggregated_in":
+			return c.AggregatedIn, true
+		case "size":
+			return c.Size, true
+		case "created_at":
+			return c.CreatedAt, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// dealColumn returns a column lookup for d matching the names
+// DealsQuery.Search's squirrel builder selects from the content_deals
+// table, for memDealsQuery.Search to evaluate a ContentFilter against.
+func dealColumn(d contentDeal) func(column string) (interface{}, bool) {
+	return func(column string) (interface{}, bool) {
+		switch column {
+		case "id":
+			return d.ID, true
+		case "content":
+			return d.Content, true
+		default:
+			return nil, false
+		}
+	}
+}
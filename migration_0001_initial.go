@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/application-research/estuary/migrations"
+	"gorm.io/gorm"
+)
+
+func init() {
+	migrations.Register(migrations.Migration{
+		Version: 1,
+		Name:    "initial schema",
+		Up:      migration0001Up,
+		Down:    migration0001Down,
+	})
+}
+
+func migration0001Up(tx *gorm.DB) error {
+	for _, model := range []interface{}{
+		&Content{},
+		&Object{},
+		&ObjRef{},
+		&Collection{},
+		&CollectionRef{},
+
+		&contentDeal{},
+		&dfeRecord{},
+		&PieceCommRecord{},
+		&proposalRecord{},
+		&retrievalFailureRecord{},
+		&retrievalSuccessRecord{},
+
+		&minerStorageAsk{},
+		&storageMiner{},
+
+		&User{},
+		&AuthToken{},
+		&InviteCode{},
+
+		&Shuttle{},
+	} {
+		if err := tx.AutoMigrate(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func migration0001Down(tx *gorm.DB) error {
+	for _, model := range []interface{}{
+		&Shuttle{},
+		&InviteCode{},
+		&AuthToken{},
+		&User{},
+		&storageMiner{},
+		&minerStorageAsk{},
+		&retrievalSuccessRecord{},
+		&retrievalFailureRecord{},
+		&proposalRecord{},
+		&PieceCommRecord{},
+		&dfeRecord{},
+		&contentDeal{},
+		&CollectionRef{},
+		&Collection{},
+		&ObjRef{},
+		&Object{},
+		&Content{},
+	} {
+		if err := tx.Migrator().DropTable(model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Package migrations is a small versioned schema migration runner, modeled
+// on the approach used by writefreely and gotosocial: each migration is a
+// numbered Go function pair (Up/Down) registered at init time, applied in
+// order inside a transaction, and recorded in a schema_migrations table so
+// the runner always knows exactly which version a database is at.
+//
+// Migrations themselves are NOT defined in this package, since they need
+// access to the gorm models they operate on. Callers register migrations
+// from their own package via Register(), typically one call per file named
+// migration_NNNN_description.go.
+package migrations
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a single versioned schema change. Version numbers must be
+// sequential starting at 1 and, once released, must never be reused or
+// reordered, since the version is persisted in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration tracks which migrations have been applied to a database.
+type SchemaMigration struct {
+	Version   int `gorm:"primarykey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+var registry []Migration
+
+// Register adds a migration to the set run by Up/Down/Status/To. It should
+// be called from an init() in the file that defines the migration.
+func Register(m Migration) {
+	registry = append(registry, m)
+}
+
+func sorted() []Migration {
+	out := make([]Migration, len(registry))
+	copy(out, registry)
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
+
+// Latest returns the highest version number registered, or 0 if none.
+func Latest() int {
+	ms := sorted()
+	if len(ms) == 0 {
+		return 0
+	}
+	return ms[len(ms)-1].Version
+}
+
+func ensureTable(tx *gorm.DB) error {
+	return tx.AutoMigrate(&SchemaMigration{})
+}
+
+func currentVersion(tx *gorm.DB) (int, error) {
+	var m SchemaMigration
+	err := tx.Order("version DESC").Take(&m).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return m.Version, nil
+}
+
+// Status reports one migration's position relative to the database's
+// current version.
+type Status struct {
+	Version int
+	Name    string
+	Applied bool
+}
+
+// StatusList returns the applied/pending state of every registered
+// migration, in version order.
+func StatusList(db *gorm.DB) ([]Status, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+	current, err := currentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Status
+	for _, m := range sorted() {
+		out = append(out, Status{Version: m.Version, Name: m.Name, Applied: m.Version <= current})
+	}
+	return out, nil
+}
+
+// Pending reports whether the database is behind the migrations compiled
+// into this binary.
+func Pending(db *gorm.DB) (bool, error) {
+	if err := ensureTable(db); err != nil {
+		return false, err
+	}
+	current, err := currentVersion(db)
+	if err != nil {
+		return false, err
+	}
+	return current < Latest(), nil
+}
+
+func applyUp(db *gorm.DB, m Migration) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := m.Up(tx); err != nil {
+			return err
+		}
+		return tx.Create(&SchemaMigration{
+			Version:   m.Version,
+			Name:      m.Name,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+}
+
+func applyDown(db *gorm.DB, m Migration) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := m.Down(tx); err != nil {
+			return err
+		}
+		return tx.Where("version = ?", m.Version).Delete(&SchemaMigration{}).Error
+	})
+}
+
+// Up runs every registered migration newer than the database's current
+// version, in order, each in its own transaction.
+func Up(db *gorm.DB) error {
+	if err := ensureTable(db); err != nil {
+		return err
+	}
+	current, err := currentVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range sorted() {
+		if m.Version <= current {
+			continue
+		}
+		if err := applyUp(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Down reverts the single most recently applied migration.
+func Down(db *gorm.DB) error {
+	if err := ensureTable(db); err != nil {
+		return err
+	}
+	current, err := currentVersion(db)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return fmt.Errorf("no migrations have been applied")
+	}
+
+	for _, m := range sorted() {
+		if m.Version == current {
+			return applyDown(db, m)
+		}
+	}
+	return fmt.Errorf("migration %d is recorded as applied but not registered in this binary", current)
+}
+
+// To migrates the database up or down to exactly the given version.
+func To(db *gorm.DB, version int) error {
+	if err := ensureTable(db); err != nil {
+		return err
+	}
+	current, err := currentVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if version > current {
+		for _, m := range sorted() {
+			if m.Version > current && m.Version <= version {
+				if err := applyUp(db, m); err != nil {
+					return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	for current > version {
+		if err := Down(db); err != nil {
+			return err
+		}
+		current, err = currentVersion(db)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package migrations_test
+
+import (
+	"testing"
+
+	"github.com/application-research/estuary/migrations"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// testMarker lets each test migration below leave its own breadcrumb, since
+// this package has no real schema of its own for its migrations to operate
+// on - only the SchemaMigration bookkeeping table the runner itself writes
+// to.
+type testMarker struct {
+	Version int `gorm:"primarykey"`
+}
+
+func init() {
+	migrations.Register(migrations.Migration{Version: 1, Name: "marker one", Up: markerUp(1), Down: markerDown(1)})
+	migrations.Register(migrations.Migration{Version: 2, Name: "marker two", Up: markerUp(2), Down: markerDown(2)})
+	migrations.Register(migrations.Migration{Version: 3, Name: "marker three", Up: markerUp(3), Down: markerDown(3)})
+}
+
+func markerUp(version int) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&testMarker{}); err != nil {
+			return err
+		}
+		return tx.Create(&testMarker{Version: version}).Error
+	}
+}
+
+func markerDown(version int) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Where("version = ?", version).Delete(&testMarker{}).Error
+	}
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("opening sqlite: %v", err)
+	}
+	return db
+}
+
+func markerExists(t *testing.T, db *gorm.DB, version int) bool {
+	t.Helper()
+
+	var count int64
+	if err := db.Model(&testMarker{}).Where("version = ?", version).Count(&count).Error; err != nil {
+		t.Fatalf("counting marker %d: %v", version, err)
+	}
+	return count > 0
+}
+
+func TestUpAppliesInOrderAndRecordsVersions(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := migrations.Up(db); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	for _, version := range []int{1, 2, 3} {
+		if !markerExists(t, db, version) {
+			t.Fatalf("marker %d missing after Up", version)
+		}
+	}
+
+	statuses, err := migrations.StatusList(db)
+	if err != nil {
+		t.Fatalf("StatusList: %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Fatalf("migration %d (%s) not recorded as applied", s.Version, s.Name)
+		}
+	}
+}
+
+func TestDownRevertsExactlyOneStep(t *testing.T) {
+	db := newTestDB(t)
+	if err := migrations.Up(db); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	if err := migrations.Down(db); err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	if markerExists(t, db, 3) {
+		t.Fatalf("marker 3 still present after Down")
+	}
+	if !markerExists(t, db, 2) || !markerExists(t, db, 1) {
+		t.Fatalf("Down reverted more than the single most recent migration")
+	}
+
+	statuses, err := migrations.StatusList(db)
+	if err != nil {
+		t.Fatalf("StatusList: %v", err)
+	}
+	for _, s := range statuses {
+		if s.Version == 3 && s.Applied {
+			t.Fatalf("migration 3 still recorded as applied after Down")
+		}
+	}
+}
+
+func TestToMovesBothDirections(t *testing.T) {
+	db := newTestDB(t)
+	if err := migrations.Up(db); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	if err := migrations.To(db, 1); err != nil {
+		t.Fatalf("To(1): %v", err)
+	}
+	if markerExists(t, db, 2) || markerExists(t, db, 3) {
+		t.Fatalf("To(1) left markers 2/3 behind")
+	}
+	if !markerExists(t, db, 1) {
+		t.Fatalf("To(1) reverted past version 1")
+	}
+
+	if err := migrations.To(db, 3); err != nil {
+		t.Fatalf("To(3): %v", err)
+	}
+	for _, version := range []int{1, 2, 3} {
+		if !markerExists(t, db, version) {
+			t.Fatalf("To(3) did not reapply marker %d", version)
+		}
+	}
+}
+
+// TestDownUndoesUp verifies that repeatedly calling Down after Up leaves no
+// trace of any migration behind - i.e. each migration's Down genuinely
+// undoes its Up, not just decrements the recorded version.
+func TestDownUndoesUp(t *testing.T) {
+	db := newTestDB(t)
+	if err := migrations.Up(db); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := migrations.Down(db); err != nil {
+			t.Fatalf("Down: %v", err)
+		}
+	}
+
+	var count int64
+	if err := db.Model(&testMarker{}).Count(&count).Error; err != nil {
+		t.Fatalf("counting markers: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("markers remain after reverting every migration: %d", count)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// maxPreparedStmts bounds how many distinct prepared statements
+// boundedStmtCache keeps open at once. gorm's built-in PrepareStmt option
+// caches every distinct query it ever sees with no eviction, which can
+// exhaust the driver's statement handle limit under high SQL cardinality
+// (e.g. IN-lists of varying length); evicting the least-recently-used
+// entry once the cache is full keeps it bounded instead.
+const maxPreparedStmts = 512
+
+type preparedStmtEntry struct {
+	query string
+	stmt  *sql.Stmt
+}
+
+// boundedStmtCache is a gorm.ConnPool backed by *sql.DB that prepares and
+// reuses statements the way gorm's PrepareStmt option does, but caps the
+// cache at maxPreparedStmts entries via LRU eviction. It's installed as
+// db.ConnPool in openRawDB so every query gorm issues goes through it.
+type boundedStmtCache struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+
+	hits   uint64
+	misses uint64
+}
+
+func newBoundedStmtCache(db *sql.DB) *boundedStmtCache {
+	return &boundedStmtCache{
+		db:      db,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *boundedStmtCache) stmtFor(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[query]; ok {
+		c.order.MoveToFront(el)
+		c.hits++
+		stmt := el.Value.(*preparedStmtEntry).stmt
+		c.mu.Unlock()
+		return stmt, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another goroutine may have raced us to prepare the same query; keep
+	// whichever entry got cached first and close the redundant statement.
+	if el, ok := c.entries[query]; ok {
+		c.order.MoveToFront(el)
+		stmt.Close()
+		return el.Value.(*preparedStmtEntry).stmt, nil
+	}
+
+	el := c.order.PushFront(&preparedStmtEntry{query: query, stmt: stmt})
+	c.entries[query] = el
+
+	if c.order.Len() > maxPreparedStmts {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		entry := oldest.Value.(*preparedStmtEntry)
+		delete(c.entries, entry.query)
+		entry.stmt.Close()
+	}
+
+	return stmt, nil
+}
+
+func (c *boundedStmtCache) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.stmtFor(ctx, query)
+}
+
+func (c *boundedStmtCache) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := c.stmtFor(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+func (c *boundedStmtCache) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := c.stmtFor(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+func (c *boundedStmtCache) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	stmt, err := c.stmtFor(ctx, query)
+	if err != nil {
+		// Fall back to an unprepared query so the caller still gets a
+		// *sql.Row carrying this error from Scan, instead of a nil row.
+		return c.db.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+// BeginTx satisfies gorm.ConnPoolBeginner, the same way gorm's own
+// PreparedStmtDB forwards to the underlying *sql.DB. Without it, db.ConnPool
+// being a boundedStmtCache makes gorm fall back to its "invalid transaction"
+// path, since *boundedStmtCache has no Begin/BeginTx of its own - breaking
+// every db.Transaction call, including the ones migrations.Up/Down run in.
+func (c *boundedStmtCache) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return c.db.BeginTx(ctx, opts)
+}
+
+// hitRate returns the fraction of prepare requests served from cache so
+// far, or 0 if none have been made yet.
+func (c *boundedStmtCache) hitRate() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	total := c.hits + c.misses
+	if total == 0 {
+		return 0
+	}
+	return float64(c.hits) / float64(total)
+}